@@ -16,10 +16,18 @@ package tsv
 //     (with optional NOT) or comparison operators (=, <, <=, >, >=, <>, !=).
 //   - WHERE also counts as valid if it contains the Grafana macro $__timeFilter
 //     (case-insensitive; we lowercase tokens).
+//   - Tokens carry their source line/column/offset so callers (editors, LSPs,
+//     Grafana's query editor) can underline the exact offending span; each
+//     Issue reports that span via Line/Column/EndLine/EndColumn.
+//   - NATURAL JOIN and JOIN ... USING are recognized alongside JOIN ... ON;
+//     UNION/INTERSECT/EXCEPT branches are each validated independently; a
+//     trailing FOR UPDATE/FOR SHARE suffix terminates WHERE scanning like
+//     GROUP BY or ORDER BY would.
 //
 // Note: This is intentionally heuristic and aims to be practical for Timestream.
 
 import (
+	"sort"
 	"strings"
 	"unicode"
 )
@@ -28,17 +36,57 @@ type Options struct {
 	// TimeColumns lists identifiers that count as the "time" column.
 	// Defaults to []string{"time", "measure_time"} if nil/empty.
 	TimeColumns []string
+
+	// RepairTemplate is the predicate Repair inserts for a missing time
+	// filter. Defaults to "$__timeFilter" if empty.
+	RepairTemplate string
+
+	// PredicateValidators are extra rules for recognizing a valid time
+	// predicate, beyond "column op value" / BETWEEN / $__timeFilter / the
+	// built-in Timestream idioms. They run in registration order, after the
+	// built-in checks, and short-circuit on the first one that returns true.
+	PredicateValidators []PredicateValidator
+
+	// TimeFunctions restricts which RHS function calls count as a time
+	// predicate, e.g. []string{"ago"} accepts "time >= ago(1h)" but rejects
+	// "time >= some_udf(x)". nil (the default) leaves any RHS unrestricted,
+	// matching prior behavior; a non-nil empty slice rejects every other RHS
+	// function call. The built-in Timestream idioms (ago, from_milliseconds,
+	// from_iso8601_timestamp) are always accepted on top of whatever is
+	// listed here. TimeFunctions only constrains comparison-operator RHS;
+	// it does not apply to BETWEEN, whose bounds are never function-checked.
+	TimeFunctions []string
 }
 
-type Issue struct {
-	Snippet string
-	Reason  string
-	AtDepth int
+// TokenKind identifies the lexical category of a Token.
+type TokenKind int
+
+const (
+	TokenIdent TokenKind = iota
+	TokenKeyword
+	TokenString
+	TokenNumber
+	TokenSymbol
+)
+
+// Token is a read-only view of a lexed token, handed to PredicateValidator
+// implementations registered via Options.PredicateValidators.
+type Token struct {
+	Val    string
+	Kind   TokenKind
+	Depth  int
+	Line   int
+	Column int
+	Offset int
 }
 
-// Validate returns true if every SELECT that directly reads from a table
-// has a WHERE time filter; otherwise returns false and the list of issues.
-func Validate(sql string, opts *Options) (bool, []Issue) {
+// PredicateValidator inspects a WHERE-body token slice, already bounded to
+// the enclosing SELECT's depth, and reports whether it recognizes a valid
+// time predicate. depth is the slice's own depth, so implementations can
+// filter with token.Depth == depth the same way the built-in checks do.
+type PredicateValidator func(tokens []Token, depth int) bool
+
+func resolveTimeCols(opts *Options) []string {
 	timeCols := []string{"time", "measure_time"}
 	if opts != nil && len(opts.TimeColumns) > 0 {
 		timeCols = make([]string, len(opts.TimeColumns))
@@ -46,76 +94,95 @@ func Validate(sql string, opts *Options) (bool, []Issue) {
 			timeCols[i] = strings.ToLower(c)
 		}
 	}
+	return timeCols
+}
 
-	src := stripComments(sql)
-	toks := lex(src)
-
-	type sel struct {
-		selIdx int
-		depth  int
-	}
-	var selects []sel
-	for i := 0; i < len(toks); i++ {
-		if toks[i].kind == tkKeyword && toks[i].val == "select" {
-			selects = append(selects, sel{selIdx: i, depth: toks[i].depth})
-		}
+func resolveRepairTemplate(opts *Options) string {
+	if opts != nil && opts.RepairTemplate != "" {
+		return opts.RepairTemplate
 	}
+	return "$__timeFilter"
+}
 
-	var issues []Issue
+type Issue struct {
+	Snippet string
+	Reason  string
+	AtDepth int
 
-	for _, s := range selects {
-		// Find FROM at same depth after this SELECT.
-		fromIdx := findNextKeywordAtDepth(toks, s.selIdx+1, s.depth, "from")
-		if fromIdx == -1 {
-			// SELECT without FROM (e.g., SELECT 1): ignore (doesn't hit DB).
-			continue
-		}
+	// Line/Column mark the start of the offending SELECT/WHERE span
+	// (1-based, as in a text editor). EndLine/EndColumn mark its end.
+	Line      int
+	Column    int
+	EndLine   int
+	EndColumn int
+}
 
-		// FROM clause ends at next clause keyword (excluding WHERE) or when depth drops.
-		stopIdx := findNextTerminatorAtDepth(toks, fromIdx+1, s.depth)
+// Validate returns true if every SELECT that directly reads from a table
+// has a WHERE time filter; otherwise returns false and the list of issues.
+func Validate(sql string, opts *Options) (bool, []Issue) {
+	toks := lex(stripComments(sql))
+	findings := diagnose(toks, opts)
 
-		// Decide if this SELECT directly reads from a base table (not subquery or CTE alias).
-		hitsDB := fromStartsWithBaseTable(toks, fromIdx+1, stopIdx, s.depth)
-		if !hitsDB {
-			// Outer SELECT over CTE/derived table — inner SELECTs will be validated separately.
-			continue
-		}
+	var issues []Issue
+	for _, f := range findings {
+		line, col, endLine, endCol := spanPosition(toks, f.spanStart, f.spanEnd)
+		issues = append(issues, Issue{
+			Snippet:   snippetAroundTokens(toks, f.spanStart, f.spanEnd),
+			Reason:    f.reason,
+			AtDepth:   f.depth,
+			Line:      line,
+			Column:    col,
+			EndLine:   endLine,
+			EndColumn: endCol,
+		})
+	}
 
-		// WHERE must be present at same depth between FROM and its terminator.
-		whereIdx := findNextKeywordBetweenAtDepth(toks, fromIdx+1, stopIdx, s.depth, "where")
-		if whereIdx == -1 {
-			issues = append(issues, Issue{
-				Snippet: snippetAroundTokens(toks, s.selIdx, stopIdx),
-				Reason:  "missing WHERE clause with time filter",
-				AtDepth: s.depth,
-			})
-			continue
-		}
+	return len(issues) == 0, issues
+}
 
-		// WHERE body ends at next clause (group/order/having/union/...) or on depth drop.
-		whereStop := findNextTerminatorAtDepth(toks, whereIdx+1, s.depth)
+// Repair runs the same analysis as Validate and, for every SELECT missing a
+// time predicate, rewrites sql to insert one (by default the Grafana macro
+// $__timeFilter, or opts.RepairTemplate if set). It returns the repaired SQL
+// together with the issues found in the original query. Comments and
+// formatting outside the rewritten spans are preserved.
+func Repair(sql string, opts *Options) (string, []Issue, error) {
+	src, origOffsets := stripCommentsWithMap(sql)
+	toks := lex(src)
+	findings := diagnose(toks, opts)
 
-		// Malformed WHERE like "WHERE\n AND ..." (no predicate before conjunction) should fail.
-		if whereStartsWithConjunction(toks, whereIdx+1, whereStop, s.depth) {
-			issues = append(issues, Issue{
-				Snippet: snippetAroundTokens(toks, s.selIdx, whereStop),
-				Reason:  "WHERE clause starts with AND/OR; no predicate before it",
-				AtDepth: s.depth,
-			})
-			continue
-		}
+	var issues []Issue
+	for _, f := range findings {
+		line, col, endLine, endCol := spanPosition(toks, f.spanStart, f.spanEnd)
+		issues = append(issues, Issue{
+			Snippet:   snippetAroundTokens(toks, f.spanStart, f.spanEnd),
+			Reason:    f.reason,
+			AtDepth:   f.depth,
+			Line:      line,
+			Column:    col,
+			EndLine:   endLine,
+			EndColumn: endCol,
+		})
+	}
 
-		// Check for time predicate or $__timeFilter macro.
-		if !whereHasTimePredicate(toks, whereIdx+1, whereStop, s.depth, timeCols) {
-			issues = append(issues, Issue{
-				Snippet: snippetAroundTokens(toks, s.selIdx, whereStop),
-				Reason:  "WHERE clause lacks a time predicate on allowed time columns",
-				AtDepth: s.depth,
-			})
-		}
+	if len(findings) == 0 {
+		return sql, issues, nil
 	}
 
-	return len(issues) == 0, issues
+	template := resolveRepairTemplate(opts)
+	edits := buildRepairEdits(toks, findings, origOffsets, len(src), template)
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	var b strings.Builder
+	b.Grow(len(sql))
+	pos := 0
+	for _, e := range edits {
+		b.WriteString(sql[pos:e.start])
+		b.WriteString(e.text)
+		pos = e.end
+	}
+	b.WriteString(sql[pos:])
+
+	return b.String(), issues, nil
 }
 
 /* -------------------- internal: lexer & helpers -------------------- */
@@ -131,27 +198,71 @@ const (
 )
 
 type token struct {
-	val   string
-	kind  tokenKind
-	depth int
+	val    string
+	kind   tokenKind
+	depth  int
+	line   int // 1-based source line the token starts on
+	col    int // 1-based column (in runes) the token starts on
+	offset int // 0-based byte offset into the lexed source
+}
+
+func (k tokenKind) public() TokenKind {
+	switch k {
+	case tkIdent:
+		return TokenIdent
+	case tkKeyword:
+		return TokenKeyword
+	case tkString:
+		return TokenString
+	case tkNumber:
+		return TokenNumber
+	default:
+		return TokenSymbol
+	}
+}
+
+// publicTokens converts a slice of internal tokens into the exported Token
+// view handed to PredicateValidator implementations.
+func publicTokens(toks []token) []Token {
+	out := make([]Token, len(toks))
+	for i, t := range toks {
+		out[i] = Token{
+			Val: t.val, Kind: t.kind.public(), Depth: t.depth,
+			Line: t.line, Column: t.col, Offset: t.offset,
+		}
+	}
+	return out
 }
 
 var keywords = map[string]struct{}{
 	"select": {}, "from": {}, "where": {}, "group": {}, "by": {}, "order": {}, "having": {},
 	"union": {}, "intersect": {}, "except": {}, "join": {}, "left": {}, "right": {}, "full": {},
-	"outer": {}, "inner": {}, "cross": {}, "on": {}, "as": {}, "with": {}, "lateral": {},
-	"between": {}, "and": {}, "or": {}, "not": {}, "in": {}, "exists": {},
+	"outer": {}, "inner": {}, "cross": {}, "natural": {}, "on": {}, "using": {}, "as": {},
+	"with": {}, "lateral": {}, "between": {}, "and": {}, "or": {}, "not": {}, "in": {},
+	"exists": {}, "for": {}, "update": {}, "share": {},
 }
 
 func stripComments(s string) string {
+	out, _ := stripCommentsWithMap(s)
+	return out
+}
+
+// stripCommentsWithMap behaves like stripComments but also returns a map from
+// each byte offset in the returned string to the corresponding byte offset in
+// s, so that Repair can splice edits back into the original, comment-bearing
+// source. The map has one extra trailing entry (len(s)) so that "insert at
+// end of source" anchors can be resolved the same way as any token offset.
+func stripCommentsWithMap(s string) (string, []int) {
 	var b strings.Builder
 	b.Grow(len(s))
+	offs := make([]int, 0, len(s)+1)
 	inLine, inBlock := false, false
 	for i := 0; i < len(s); i++ {
 		if inLine {
 			if s[i] == '\n' {
 				inLine = false
 				b.WriteByte(s[i])
+				offs = append(offs, i)
 			}
 			continue
 		}
@@ -173,13 +284,29 @@ func stripComments(s string) string {
 			continue
 		}
 		b.WriteByte(s[i])
+		offs = append(offs, i)
 	}
-	return b.String()
+	offs = append(offs, len(s))
+	return b.String(), offs
 }
 
 func lex(s string) []token {
 	var out []token
 	depth := 0
+	line, col := 1, 1
+
+	// advance moves the line/col cursor past s[from:to], honoring any
+	// newline runes found along the way.
+	advance := func(from, to int) {
+		for _, r := range s[from:to] {
+			if r == '\n' {
+				line++
+				col = 1
+			} else {
+				col++
+			}
+		}
+	}
 
 	readString := func(i int, quote byte) (string, int) {
 		j := i + 1
@@ -203,12 +330,22 @@ func lex(s string) []token {
 		// Handle literal escape sequences often present in serialized SQL (e.g., "\n", \"Device\")
 		if r == '\\' && i+1 < len(s) {
 			switch s[i+1] {
-			case 'n', 'r', 't':
+			case 'n':
+				// serialized newline: treat as whitespace but still advance
+				// the line counter so reported positions match what a user
+				// sees in their editor for JSON-escaped payloads.
+				line++
+				col = 1
+				i += 2
+				continue
+			case 'r', 't':
 				// treat as whitespace: skip both
+				col += 2
 				i += 2
 				continue
 			case '"', '\'', '\\':
 				// skip the backslash; next loop will process the quoted char
+				col++
 				i++
 				continue
 			}
@@ -217,13 +354,20 @@ func lex(s string) []token {
 
 		// whitespace
 		if unicode.IsSpace(rune(r)) {
+			if r == '\n' {
+				line++
+				col = 1
+			} else {
+				col++
+			}
 			i++
 			continue
 		}
 		// parentheses adjust depth
 		if r == '(' {
-			out = append(out, token{val: "(", kind: tkSymbol, depth: depth})
+			out = append(out, token{val: "(", kind: tkSymbol, depth: depth, line: line, col: col, offset: i})
 			depth++
+			col++
 			i++
 			continue
 		}
@@ -232,19 +376,22 @@ func lex(s string) []token {
 			if depth < 0 {
 				depth = 0
 			}
-			out = append(out, token{val: ")", kind: tkSymbol, depth: depth})
+			out = append(out, token{val: ")", kind: tkSymbol, depth: depth, line: line, col: col, offset: i})
+			col++
 			i++
 			continue
 		}
 		// strings / quoted identifiers
 		if r == '\'' || r == '"' {
+			startLine, startCol := line, col
 			str, nx := readString(i, r)
 			if r == '"' {
 				// treat "ident" as identifier (lowercased, quotes kept for context)
-				out = append(out, token{val: strings.ToLower(str), kind: tkIdent, depth: depth})
+				out = append(out, token{val: strings.ToLower(str), kind: tkIdent, depth: depth, line: startLine, col: startCol, offset: i})
 			} else {
-				out = append(out, token{val: str, kind: tkString, depth: depth})
+				out = append(out, token{val: str, kind: tkString, depth: depth, line: startLine, col: startCol, offset: i})
 			}
+			advance(i, nx)
 			i = nx
 			continue
 		}
@@ -254,7 +401,8 @@ func lex(s string) []token {
 			for j < len(s) && (isNum(s[j]) || s[j] == '.') {
 				j++
 			}
-			out = append(out, token{val: s[i:j], kind: tkNumber, depth: depth})
+			out = append(out, token{val: s[i:j], kind: tkNumber, depth: depth, line: line, col: col, offset: i})
+			advance(i, j)
 			i = j
 			continue
 		}
@@ -266,10 +414,11 @@ func lex(s string) []token {
 			}
 			word := strings.ToLower(s[i:j])
 			if _, ok := keywords[word]; ok {
-				out = append(out, token{val: word, kind: tkKeyword, depth: depth})
+				out = append(out, token{val: word, kind: tkKeyword, depth: depth, line: line, col: col, offset: i})
 			} else {
-				out = append(out, token{val: word, kind: tkIdent, depth: depth})
+				out = append(out, token{val: word, kind: tkIdent, depth: depth, line: line, col: col, offset: i})
 			}
+			advance(i, j)
 			i = j
 			continue
 		}
@@ -277,13 +426,15 @@ func lex(s string) []token {
 		if (r == '>' || r == '<' || r == '!') && i+1 < len(s) {
 			n := s[i+1]
 			if (r == '>' && n == '=') || (r == '<' && (n == '=' || n == '>')) || (r == '!' && n == '=') {
-				out = append(out, token{val: strings.ToLower(s[i : i+2]), kind: tkSymbol, depth: depth})
+				out = append(out, token{val: strings.ToLower(s[i : i+2]), kind: tkSymbol, depth: depth, line: line, col: col, offset: i})
+				col += 2
 				i += 2
 				continue
 			}
 		}
 		// single-char symbols
-		out = append(out, token{val: strings.ToLower(string(r)), kind: tkSymbol, depth: depth})
+		out = append(out, token{val: strings.ToLower(string(r)), kind: tkSymbol, depth: depth, line: line, col: col, offset: i})
+		col++
 		i++
 	}
 	return out
@@ -336,10 +487,11 @@ func findNextTerminatorAtDepth(toks []token, start, depth int) int {
 		if toks[i].depth < depth {
 			return i
 		}
-		// Clause terminators at the same depth.
+		// Clause terminators at the same depth. "for" covers the trailing
+		// FOR UPDATE / FOR SHARE locking suffix some dialects allow.
 		if toks[i].depth == depth && toks[i].kind == tkKeyword {
 			switch toks[i].val {
-			case "group", "order", "having", "union", "intersect", "except":
+			case "group", "order", "having", "union", "intersect", "except", "for":
 				return i
 			}
 		}
@@ -347,13 +499,39 @@ func findNextTerminatorAtDepth(toks []token, start, depth int) int {
 	return len(toks)
 }
 
-// Returns true if FROM's first source at this depth looks like a base table:
+// Returns true if the FROM clause at this depth references at least one base
+// table, whether as the first source or as the right-hand side of a
+// JOIN/NATURAL JOIN:
 //   - single identifier containing a dot (db.table or $__db.$__table) and not a function call
 //   - pattern: ident '.' ident  (covers "db"."table" and unquoted db.table split into parts)
 //
+// Returns false if every source is a subquery or CTE alias (e.g. a FROM
+// clause that only ever joins aliases together).
+func fromStartsWithBaseTable(toks []token, start, stop, depth int) bool {
+	sourceStarts := []int{start}
+	for i := start; i < stop && i < len(toks); i++ {
+		// Each JOIN introduces another source; its table reference starts
+		// right after the "join" keyword (any NATURAL/LEFT/INNER/etc. prefix
+		// keyword has already been skipped by the time we reach it here).
+		if toks[i].depth == depth && toks[i].kind == tkKeyword && toks[i].val == "join" {
+			sourceStarts = append(sourceStarts, i+1)
+		}
+	}
+
+	for _, srcStart := range sourceStarts {
+		if isBaseTableSourceAt(toks, srcStart, stop, depth) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBaseTableSourceAt checks whether the FROM source starting at start looks
+// like a base table reference.
+//
 // Skips over stray symbols/keywords (e.g., serialized "\n").
 // Returns false for '(' (subquery) or single-part identifier (likely CTE alias).
-func fromStartsWithBaseTable(toks []token, start, stop, depth int) bool {
+func isBaseTableSourceAt(toks []token, start, stop, depth int) bool {
 	i := start
 
 	// Advance to first meaningful token at this depth
@@ -414,8 +592,11 @@ func fromStartsWithBaseTable(toks []token, start, stop, depth int) bool {
 
 // True if WHERE body is empty or begins with AND/OR (malformed "WHERE\n AND ...").
 // Skips stray serialized escape tokens like "\n" (backslash + 'n').
-func whereStartsWithConjunction(toks []token, start, stop, depth int) bool {
-	// find first meaningful token at this depth
+// firstMeaningfulTokenIndex returns the index of the first token in
+// toks[start:stop] at the given depth, skipping stray symbols and any
+// backslash + 'n' token pair (the lexer itself consumes serialized "\n").
+// Returns -1 if the range holds nothing meaningful (e.g. an empty WHERE body).
+func firstMeaningfulTokenIndex(toks []token, start, stop, depth int) int {
 	i := start
 	for i < stop && i < len(toks) {
 		if toks[i].depth != depth {
@@ -433,6 +614,12 @@ func whereStartsWithConjunction(toks []token, start, stop, depth int) bool {
 			i++
 			continue
 		}
+		// An opening paren starts a parenthesized predicate, e.g.
+		// "WHERE (measure_name='x')"; that's meaningful content, not a
+		// stray symbol to skip over.
+		if toks[i].kind == tkSymbol && toks[i].val == "(" {
+			break
+		}
 		// Skip other stray symbols at this depth
 		if toks[i].kind == tkSymbol {
 			i++
@@ -441,12 +628,47 @@ func whereStartsWithConjunction(toks []token, start, stop, depth int) bool {
 		break
 	}
 	if i >= stop || i >= len(toks) {
+		return -1
+	}
+	return i
+}
+
+func whereStartsWithConjunction(toks []token, start, stop, depth int) bool {
+	i := firstMeaningfulTokenIndex(toks, start, stop, depth)
+	if i == -1 {
 		return true // empty WHERE body
 	}
 	return toks[i].kind == tkKeyword && (toks[i].val == "and" || toks[i].val == "or")
 }
 
-func whereHasTimePredicate(toks []token, start, stop, depth int, timeCols []string) bool {
+// predicateRules bundles the per-Options knobs that shape what counts as a
+// valid time predicate, resolved once per Validate/Repair call.
+type predicateRules struct {
+	timeCols  []string
+	timeFuncs []string // nil means "any RHS function is accepted"
+	custom    []PredicateValidator
+}
+
+func resolveRules(opts *Options) predicateRules {
+	r := predicateRules{timeCols: resolveTimeCols(opts), timeFuncs: resolveTimeFuncs(opts)}
+	if opts != nil {
+		r.custom = opts.PredicateValidators
+	}
+	return r
+}
+
+func resolveTimeFuncs(opts *Options) []string {
+	if opts == nil || opts.TimeFunctions == nil {
+		return nil
+	}
+	out := make([]string, len(opts.TimeFunctions))
+	for i, f := range opts.TimeFunctions {
+		out[i] = strings.ToLower(f)
+	}
+	return out
+}
+
+func whereHasTimePredicate(toks []token, start, stop, depth int, rules predicateRules) bool {
 	if stop < 0 {
 		stop = len(toks)
 	}
@@ -460,13 +682,18 @@ func whereHasTimePredicate(toks []token, start, stop, depth int, timeCols []stri
 		}
 	}
 
+	// Built-in Timestream idiom: bin(time, <interval>) <cmp> <value>.
+	if binTimeComparisonAt(toks, start, stop, depth, rules) {
+		return true
+	}
+
 	for i := start; i < stop && i < len(toks); i++ {
 		if toks[i].depth != depth {
 			continue
 		}
 
 		// Simple comparisons: time [op] ...
-		if ok, _ := isTimeIdentifierAt(toks, i, depth, timeCols); ok {
+		if ok, _ := isTimeIdentifierAt(toks, i, depth, rules.timeCols); ok {
 			j := i + 1
 			for j < stop && toks[j].depth != depth {
 				j++
@@ -485,9 +712,11 @@ func whereHasTimePredicate(toks []token, start, stop, depth int, timeCols []stri
 			if j < stop && toks[j].kind == tkKeyword && toks[j].val == "between" {
 				return true
 			}
-			// Comparison operator
+			// Comparison operator; RHS function calls are subject to rules.timeFuncs.
 			if j < stop && toks[j].kind == tkSymbol && isCompareOp(toks[j].val) {
-				return true
+				if rhsFunctionAllowed(toks, j+1, stop, depth, rules.timeFuncs) {
+					return true
+				}
 			}
 		}
 
@@ -500,15 +729,104 @@ func whereHasTimePredicate(toks []token, start, stop, depth int, timeCols []stri
 				if toks[k].kind == tkKeyword && toks[k].val == "not" {
 					continue
 				}
-				if ok, _ := isTimeIdentifierAt(toks, k, depth, timeCols); ok {
+				if ok, _ := isTimeIdentifierAt(toks, k, depth, rules.timeCols); ok {
 					return true
 				}
 			}
 		}
 	}
+
+	// User-registered validators get a final say, bounded to this WHERE body.
+	end := stop
+	if end > len(toks) {
+		end = len(toks)
+	}
+	if start <= end {
+		view := publicTokens(toks[start:end])
+		for _, v := range rules.custom {
+			if v(view, depth) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// binTimeComparisonAt recognizes the Timestream idiom
+// bin(time, <interval>) <cmp> <value>, which the plain identifier scan above
+// misses because "time" sits one depth deeper, inside the bin(...) call.
+func binTimeComparisonAt(toks []token, start, stop, depth int, rules predicateRules) bool {
+	for i := start; i < stop && i < len(toks); i++ {
+		if toks[i].depth != depth || toks[i].kind != tkIdent || toks[i].val != "bin" {
+			continue
+		}
+
+		j := i + 1
+		for j < stop && j < len(toks) && toks[j].depth != depth {
+			j++
+		}
+		if j >= stop || j >= len(toks) || toks[j].kind != tkSymbol || toks[j].val != "(" {
+			continue
+		}
+
+		argIdx := firstMeaningfulTokenIndex(toks, j+1, stop, depth+1)
+		if argIdx == -1 {
+			continue
+		}
+		if ok, _ := isTimeIdentifierAt(toks, argIdx, depth+1, rules.timeCols); !ok {
+			continue
+		}
+
+		// Skip to the matching ')', back at the enclosing depth.
+		k := argIdx + 1
+		for k < stop && k < len(toks) && !(toks[k].depth == depth && toks[k].kind == tkSymbol && toks[k].val == ")") {
+			k++
+		}
+		if k >= stop || k >= len(toks) {
+			continue
+		}
+
+		m := k + 1
+		for m < stop && m < len(toks) && toks[m].depth != depth {
+			m++
+		}
+		if m < stop && m < len(toks) && toks[m].kind == tkSymbol && isCompareOp(toks[m].val) {
+			if rhsFunctionAllowed(toks, m+1, stop, depth, rules.timeFuncs) {
+				return true
+			}
+		}
+	}
 	return false
 }
 
+// builtinTimeFuncs are the Timestream time-construction idioms that always
+// count as a valid comparison RHS, regardless of a caller's TimeFunctions
+// allowlist.
+var builtinTimeFuncs = []string{"ago", "from_milliseconds", "from_iso8601_timestamp"}
+
+// rhsFunctionAllowed reports whether the value starting at toks[start] may
+// stand as the RHS of a time comparison. Bare values/columns always pass;
+// a function call is subject to timeFuncs (nil means unrestricted), with the
+// built-in Timestream idioms always accepted on top of that list.
+func rhsFunctionAllowed(toks []token, start, stop, depth int, timeFuncs []string) bool {
+	if timeFuncs == nil {
+		return true
+	}
+	i := firstMeaningfulTokenIndex(toks, start, stop, depth)
+	if i == -1 || toks[i].kind != tkIdent {
+		return true
+	}
+	j := i + 1
+	for j < stop && j < len(toks) && toks[j].depth != depth {
+		j++
+	}
+	if j >= stop || j >= len(toks) || toks[j].kind != tkSymbol || toks[j].val != "(" {
+		return true // not a function call
+	}
+	return inStrSlice(toks[i].val, builtinTimeFuncs) || inStrSlice(toks[i].val, timeFuncs)
+}
+
 func isCompareOp(s string) bool {
 	switch s {
 	case "=", "<", ">", "<=", ">=", "<>", "!=":
@@ -530,7 +848,10 @@ func isTimeIdentifierAt(toks []token, i, depth int, timeCols []string) (bool, st
 	if i < 0 || i >= len(toks) {
 		return false, ""
 	}
-	if toks[i].depth != depth || toks[i].kind != tkIdent {
+	// Columns are normally tkIdent, but a caller-supplied TimeColumns entry
+	// may collide with a reserved word (e.g. "share"); accept tkKeyword too
+	// so configuring such a column doesn't silently stop being recognized.
+	if toks[i].depth != depth || (toks[i].kind != tkIdent && toks[i].kind != tkKeyword) {
 		return false, ""
 	}
 
@@ -547,7 +868,7 @@ func isTimeIdentifierAt(toks []token, i, depth int, timeCols []string) (bool, st
 	// ident '.' ident (handles "s1"."time")
 	if i+2 < len(toks) &&
 		toks[i+1].depth == depth && toks[i+1].kind == tkSymbol && toks[i+1].val == "." &&
-		toks[i+2].depth == depth && toks[i+2].kind == tkIdent {
+		toks[i+2].depth == depth && (toks[i+2].kind == tkIdent || toks[i+2].kind == tkKeyword) {
 		last := stripQuotes(toks[i+2].val)
 		if inStrSlice(last, timeCols) {
 			return true, last
@@ -571,6 +892,182 @@ func inStrSlice(s string, arr []string) bool {
 	return false
 }
 
+/* -------------------- shared Validate/Repair analysis -------------------- */
+
+type findingKind int
+
+const (
+	findingMissingWhere findingKind = iota
+	findingConjunction
+	findingNoTimePredicate
+)
+
+// finding describes one SELECT missing a time predicate, carrying both the
+// Issue-rendering span (spanStart/spanEnd) and enough token-index anchors for
+// Repair to splice a fix into the original source.
+type finding struct {
+	kind   findingKind
+	depth  int
+	reason string
+
+	spanStart, spanEnd int // token range covering the Issue snippet/position
+
+	// anchorIdx is where Repair inserts or replaces text:
+	//   - findingMissingWhere: token index of the FROM-clause terminator
+	//     (may equal len(toks) when the SELECT block runs to EOF)
+	//   - findingConjunction: index of the leading AND/OR token, or -1 if the
+	//     WHERE body is empty (use whereStop instead)
+	//   - findingNoTimePredicate: index of the first predicate token
+	anchorIdx int
+	whereStop int // fallback anchor for findingConjunction when anchorIdx == -1
+}
+
+// diagnose walks every SELECT that directly reads from a base table and
+// reports those missing a time predicate. It is the single source of truth
+// shared by Validate (which renders findings as Issues) and Repair (which
+// also uses the anchors to rewrite the query).
+func diagnose(toks []token, opts *Options) []finding {
+	rules := resolveRules(opts)
+
+	type sel struct {
+		selIdx int
+		depth  int
+	}
+	var selects []sel
+	for i := 0; i < len(toks); i++ {
+		if toks[i].kind == tkKeyword && toks[i].val == "select" {
+			selects = append(selects, sel{selIdx: i, depth: toks[i].depth})
+		}
+	}
+
+	var findings []finding
+
+	for _, s := range selects {
+		fromIdx := findNextKeywordAtDepth(toks, s.selIdx+1, s.depth, "from")
+		if fromIdx == -1 {
+			// SELECT without FROM (e.g., SELECT 1): ignore (doesn't hit DB).
+			continue
+		}
+
+		stopIdx := findNextTerminatorAtDepth(toks, fromIdx+1, s.depth)
+
+		hitsDB := fromStartsWithBaseTable(toks, fromIdx+1, stopIdx, s.depth)
+		if !hitsDB {
+			// Outer SELECT over CTE/derived table — inner SELECTs are diagnosed separately.
+			continue
+		}
+
+		whereIdx := findNextKeywordBetweenAtDepth(toks, fromIdx+1, stopIdx, s.depth, "where")
+		if whereIdx == -1 {
+			findings = append(findings, finding{
+				kind:      findingMissingWhere,
+				depth:     s.depth,
+				reason:    "missing WHERE clause with time filter",
+				spanStart: s.selIdx,
+				spanEnd:   stopIdx,
+				anchorIdx: stopIdx,
+			})
+			continue
+		}
+
+		whereStop := findNextTerminatorAtDepth(toks, whereIdx+1, s.depth)
+
+		if whereStartsWithConjunction(toks, whereIdx+1, whereStop, s.depth) {
+			findings = append(findings, finding{
+				kind:      findingConjunction,
+				depth:     s.depth,
+				reason:    "WHERE clause starts with AND/OR; no predicate before it",
+				spanStart: s.selIdx,
+				spanEnd:   whereStop,
+				anchorIdx: firstMeaningfulTokenIndex(toks, whereIdx+1, whereStop, s.depth),
+				whereStop: whereStop,
+			})
+			continue
+		}
+
+		if !whereHasTimePredicate(toks, whereIdx+1, whereStop, s.depth, rules) {
+			findings = append(findings, finding{
+				kind:      findingNoTimePredicate,
+				depth:     s.depth,
+				reason:    "WHERE clause lacks a time predicate on allowed time columns",
+				spanStart: s.selIdx,
+				spanEnd:   whereStop,
+				anchorIdx: firstMeaningfulTokenIndex(toks, whereIdx+1, whereStop, s.depth),
+			})
+		}
+	}
+
+	return findings
+}
+
+// edit replaces sql[start:end] (a byte range in the ORIGINAL, comment-bearing
+// source) with text. start == end represents a pure insertion.
+type edit struct {
+	start, end int
+	text       string
+}
+
+// buildRepairEdits translates findings into edits against the original sql
+// text, using origOffsets (as produced by stripCommentsWithMap) to map token
+// offsets in the comment-stripped source back to the original byte offsets.
+func buildRepairEdits(toks []token, findings []finding, origOffsets []int, srcLen int, template string) []edit {
+	tokStart := func(idx int) int {
+		if idx >= len(toks) {
+			return origOffsets[srcLen]
+		}
+		return origOffsets[toks[idx].offset]
+	}
+	var edits []edit
+	for _, f := range findings {
+		switch f.kind {
+		case findingMissingWhere:
+			pos := tokStart(f.anchorIdx)
+			edits = append(edits, edit{start: pos, end: pos, text: " WHERE " + template + " "})
+
+		case findingConjunction:
+			if f.anchorIdx == -1 {
+				pos := tokStart(f.whereStop)
+				edits = append(edits, edit{start: pos, end: pos, text: " " + template + " "})
+				continue
+			}
+			// Insert before the dangling AND/OR rather than overwriting it,
+			// so the token keeps linking the template to the existing predicate.
+			pos := tokStart(f.anchorIdx)
+			edits = append(edits, edit{start: pos, end: pos, text: template + " "})
+
+		case findingNoTimePredicate:
+			pos := tokStart(f.anchorIdx)
+			edits = append(edits, edit{start: pos, end: pos, text: template + " AND "})
+		}
+	}
+	return edits
+}
+
+// spanPosition returns the start/end position of toks[start:stop], suitable
+// for editor/LSP underlining of the offending SELECT/WHERE span.
+func spanPosition(toks []token, start, stop int) (line, col, endLine, endCol int) {
+	if len(toks) == 0 {
+		return 0, 0, 0, 0
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start >= len(toks) {
+		start = len(toks) - 1
+	}
+	if stop < 0 || stop > len(toks) {
+		stop = len(toks)
+	}
+	end := stop - 1
+	if end < start {
+		end = start
+	}
+	line, col = toks[start].line, toks[start].col
+	endLine = toks[end].line
+	endCol = toks[end].col + len([]rune(toks[end].val))
+	return
+}
+
 func snippetAroundTokens(toks []token, start, stop int) string {
 	if start < 0 {
 		start = 0
@@ -68,6 +68,124 @@ JOIN mydb.s2 ON s1.device = s2.device
 WHERE s1.device <> ''`,
 			want: false,
 		},
+		{
+			desc: "NATURAL JOIN with time filter",
+			input: `
+SELECT *
+FROM mydb.s1
+NATURAL JOIN mydb.s2
+WHERE time >= ago(1h)`,
+			want: true,
+		},
+		{
+			desc: "NATURAL JOIN without time filter",
+			input: `
+SELECT *
+FROM mydb.s1
+NATURAL JOIN mydb.s2
+WHERE s1.device <> ''`,
+			want: false,
+		},
+		{
+			desc: "NATURAL JOIN with the base table on the right side, no time filter",
+			input: `
+SELECT *
+FROM a
+NATURAL JOIN mydb.s2
+WHERE s2.device <> ''`,
+			want: false,
+		},
+		{
+			desc: "NATURAL JOIN with the base table on the right side and a time filter",
+			input: `
+SELECT *
+FROM a
+NATURAL JOIN mydb.s2
+WHERE time >= ago(1h)`,
+			want: true,
+		},
+		{
+			desc: "JOIN ... USING with time filter",
+			input: `
+SELECT *
+FROM mydb.s1
+JOIN mydb.s2 USING (device)
+WHERE time >= ago(1h)`,
+			want: true,
+		},
+		{
+			desc: "JOIN ... USING without time filter",
+			input: `
+SELECT *
+FROM mydb.s1
+JOIN mydb.s2 USING (device)
+WHERE s1.device <> ''`,
+			want: false,
+		},
+		{
+			desc: "INTERSECT with both sides filtered",
+			input: `
+SELECT *
+FROM mydb.s1
+WHERE time >= ago(1h)
+INTERSECT
+SELECT *
+FROM mydb.s2
+WHERE time >= ago(1h)`,
+			want: true,
+		},
+		{
+			desc: "INTERSECT with one side missing time filter",
+			input: `
+SELECT *
+FROM mydb.s1
+WHERE time >= ago(1h)
+INTERSECT
+SELECT *
+FROM mydb.s2`,
+			want: false,
+		},
+		{
+			desc: "EXCEPT with both sides filtered",
+			input: `
+SELECT *
+FROM mydb.s1
+WHERE time >= ago(1h)
+EXCEPT
+SELECT *
+FROM mydb.s2
+WHERE time >= ago(1h)`,
+			want: true,
+		},
+		{
+			desc: "EXCEPT with one side missing time filter",
+			input: `
+SELECT *
+FROM mydb.s1
+EXCEPT
+SELECT *
+FROM mydb.s2
+WHERE time >= ago(1h)`,
+			want: false,
+		},
+		{
+			desc: "FOR UPDATE suffix does not hide a present time filter",
+			input: `
+SELECT *
+FROM mydb.s1
+WHERE time >= ago(1h)
+FOR UPDATE`,
+			want: true,
+		},
+		{
+			desc: "FOR SHARE suffix does not mask a missing time filter",
+			input: `
+SELECT *
+FROM mydb.s1
+WHERE s1.device <> ''
+FOR SHARE`,
+			want: false,
+		},
 		{
 			desc: "CTEs (both sources time-filtered)",
 			input: `
@@ -262,3 +380,225 @@ ORDER BY
 		})
 	}
 }
+
+func TestValidate_BinTimeIdiom(t *testing.T) {
+	t.Parallel()
+
+	// The plain identifier scan alone would miss this: "time" sits one depth
+	// deeper, inside the bin(...) call.
+	ok, issues := Validate(`
+SELECT bin(time, 5m) AS t, avg(measure_value::double)
+FROM mydb.sensors
+WHERE bin(time, 5m) = ago(1h)
+GROUP BY bin(time, 5m)`, nil)
+	if !ok {
+		t.Fatalf("want valid, got issues: %+v", issues)
+	}
+}
+
+func TestValidate_TimeFunctions(t *testing.T) {
+	t.Parallel()
+
+	input := `
+SELECT *
+FROM mydb.sensors
+WHERE time >= some_udf(1)`
+
+	t.Run("unset: any RHS function is accepted", func(t *testing.T) {
+		t.Parallel()
+		ok, issues := Validate(input, nil)
+		if !ok {
+			t.Fatalf("want valid, got issues: %+v", issues)
+		}
+	})
+
+	t.Run("set: only allowlisted RHS functions are accepted", func(t *testing.T) {
+		t.Parallel()
+		ok, _ := Validate(input, &Options{TimeFunctions: []string{"ago"}})
+		if ok {
+			t.Fatalf("want invalid (some_udf not allowlisted)")
+		}
+
+		ok, issues := Validate(`
+SELECT *
+FROM mydb.sensors
+WHERE time >= ago(1h)`, &Options{TimeFunctions: []string{"ago"}})
+		if !ok {
+			t.Fatalf("want valid, got issues: %+v", issues)
+		}
+	})
+
+	t.Run("bare RHS values are always accepted regardless of TimeFunctions", func(t *testing.T) {
+		t.Parallel()
+		ok, issues := Validate(`
+SELECT *
+FROM mydb.sensors
+WHERE time >= 1000`, &Options{TimeFunctions: []string{"ago"}})
+		if !ok {
+			t.Fatalf("want valid, got issues: %+v", issues)
+		}
+	})
+
+	t.Run("built-in Timestream idioms stay allowed alongside a restrictive TimeFunctions", func(t *testing.T) {
+		t.Parallel()
+		for _, sql := range []string{
+			"SELECT *\nFROM mydb.sensors\nWHERE time >= from_milliseconds(0)",
+			"SELECT *\nFROM mydb.sensors\nWHERE time >= from_iso8601_timestamp('2023-01-01T00:00:00Z')",
+		} {
+			ok, issues := Validate(sql, &Options{TimeFunctions: []string{"ago"}})
+			if !ok {
+				t.Errorf("want valid for %q, got issues: %+v", sql, issues)
+			}
+		}
+	})
+}
+
+func TestValidate_CustomPredicateValidator(t *testing.T) {
+	t.Parallel()
+
+	// A validator that accepts "device_id = 'probe'" as a stand-in time
+	// predicate, purely to exercise the extension point.
+	acceptsDeviceIDPredicate := func(tokens []Token, depth int) bool {
+		for i, tok := range tokens {
+			if tok.Depth == depth && tok.Kind == TokenIdent && tok.Val == "device_id" {
+				if i+2 < len(tokens) && tokens[i+1].Val == "=" && tokens[i+2].Kind == TokenString {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	input := `
+SELECT *
+FROM mydb.sensors
+WHERE device_id = 'probe'`
+
+	ok, issues := Validate(input, nil)
+	if ok {
+		t.Fatalf("want invalid without the custom validator, got ok")
+	}
+
+	ok, issues = Validate(input, &Options{PredicateValidators: []PredicateValidator{acceptsDeviceIDPredicate}})
+	if !ok {
+		t.Fatalf("want valid with the custom validator, got issues: %+v", issues)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		desc string
+		in   string
+		opts *Options
+		want string
+	}{
+		{
+			desc: "no WHERE clause: inserts one before end of SELECT",
+			in:   "SELECT *\nFROM mydb.sensors",
+			want: "SELECT *\nFROM mydb.sensors WHERE $__timeFilter ",
+		},
+		{
+			desc: "no WHERE clause: inserts before GROUP BY",
+			in:   "SELECT device\nFROM mydb.sensors\nGROUP BY device",
+			want: "SELECT device\nFROM mydb.sensors\n WHERE $__timeFilter GROUP BY device",
+		},
+		{
+			desc: "WHERE starts with dangling AND",
+			in:   "SELECT *\nFROM mydb.sensors\nWHERE\n AND measure_name = 'cpu'",
+			want: "SELECT *\nFROM mydb.sensors\nWHERE\n $__timeFilter AND measure_name = 'cpu'",
+		},
+		{
+			desc: "empty WHERE body immediately followed by GROUP BY",
+			in:   "SELECT device\nFROM mydb.sensors\nWHERE\nGROUP BY device",
+			want: "SELECT device\nFROM mydb.sensors\nWHERE\n $__timeFilter GROUP BY device",
+		},
+		{
+			desc: "WHERE present but lacks a time predicate",
+			in:   "SELECT *\nFROM mydb.sensors\nWHERE measure_name = 'cpu'",
+			want: "SELECT *\nFROM mydb.sensors\nWHERE $__timeFilter AND measure_name = 'cpu'",
+		},
+		{
+			desc: "WHERE whose sole predicate is parenthesized gets a connecting AND",
+			in:   "SELECT *\nFROM mydb.sensors\nWHERE (measure_name = 'cpu')",
+			want: "SELECT *\nFROM mydb.sensors\nWHERE $__timeFilter AND (measure_name = 'cpu')",
+		},
+		{
+			desc: "already valid query is left untouched",
+			in:   "SELECT *\nFROM mydb.sensors\nWHERE time >= ago(15m)",
+			want: "SELECT *\nFROM mydb.sensors\nWHERE time >= ago(15m)",
+		},
+		{
+			desc: "custom RepairTemplate",
+			in:   "SELECT *\nFROM mydb.sensors\nWHERE measure_name = 'cpu'",
+			opts: &Options{RepairTemplate: "time >= ago(1h)"},
+			want: "SELECT *\nFROM mydb.sensors\nWHERE time >= ago(1h) AND measure_name = 'cpu'",
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			got, _, err := Repair(tc.in, tc.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("want:\n%s\ngot:\n%s", tc.want, got)
+			}
+			if ok, issues := Validate(got, tc.opts); !ok {
+				t.Errorf("repaired query still invalid: %+v", issues)
+			}
+		})
+	}
+}
+
+func TestValidate_IssuePositions(t *testing.T) {
+	t.Parallel()
+
+	t.Run("missing WHERE reports SELECT position", func(t *testing.T) {
+		t.Parallel()
+		input := "SELECT *\nFROM mydb.sensors"
+		ok, issues := Validate(input, nil)
+		if ok || len(issues) != 1 {
+			t.Fatalf("want one issue, got ok=%v issues=%+v", ok, issues)
+		}
+		iss := issues[0]
+		if iss.Line != 1 || iss.Column != 1 {
+			t.Errorf("want start 1:1, got %d:%d", iss.Line, iss.Column)
+		}
+		if iss.EndLine != 2 {
+			t.Errorf("want end on line 2, got %d", iss.EndLine)
+		}
+	})
+
+	t.Run("serialized \\n escape pair advances line like a real newline", func(t *testing.T) {
+		t.Parallel()
+		input := `SELECT *\nFROM mydb.sensors\nWHERE measure_name = 'cpu'`
+		ok, issues := Validate(input, nil)
+		if ok || len(issues) != 1 {
+			t.Fatalf("want one issue, got ok=%v issues=%+v", ok, issues)
+		}
+		iss := issues[0]
+		if iss.Line != 1 || iss.Column != 1 {
+			t.Errorf("want start 1:1, got %d:%d", iss.Line, iss.Column)
+		}
+		if iss.EndLine != 3 {
+			t.Errorf("want end on line 3 (two \\n pairs consumed), got %d", iss.EndLine)
+		}
+	})
+
+	t.Run("FOR UPDATE suffix does not leak into the issue span", func(t *testing.T) {
+		t.Parallel()
+		input := "SELECT *\nFROM mydb.sensors\nWHERE measure_name = 'cpu'\nFOR UPDATE"
+		ok, issues := Validate(input, nil)
+		if ok || len(issues) != 1 {
+			t.Fatalf("want one issue, got ok=%v issues=%+v", ok, issues)
+		}
+		if iss := issues[0]; iss.EndLine != 3 {
+			t.Errorf("want span to end on line 3, before FOR UPDATE, got %d", iss.EndLine)
+		}
+	})
+}